@@ -0,0 +1,195 @@
+// Package circuitbreaker implements a per-destination-host circuit breaker,
+// so a failing downstream target short-circuits with an immediate retryable
+// error instead of consuming worker slots and burning through timeouts.
+package circuitbreaker
+
+import (
+	"github.com/LinioIT/rabbitmq-worker/logfile"
+	"github.com/LinioIT/rabbitmq-worker/metrics"
+	"sync"
+	"time"
+)
+
+type state int
+
+const (
+	closed state = iota
+	open
+	halfOpen
+)
+
+// Config controls when a host's breaker trips open and how it recovers.
+type Config struct {
+	// WindowSize is the number of most recent outcomes considered when
+	// computing the failure ratio.
+	WindowSize int
+
+	// FailureThreshold is the failure ratio (0.0-1.0) above which the
+	// breaker trips open once WindowSize outcomes have been recorded; a
+	// ratio exactly equal to it does not trip the breaker.
+	FailureThreshold float64
+
+	// CooldownDuration is how long an open breaker waits before allowing
+	// a half-open probe request through.
+	CooldownDuration time.Duration
+
+	// HalfOpenProbes is the number of requests allowed through while
+	// half-open, before the breaker closes (on success) or re-opens (on
+	// failure).
+	HalfOpenProbes int
+}
+
+// DefaultConfig returns the breaker configuration applied when none is supplied.
+func DefaultConfig() Config {
+	return Config{
+		WindowSize:       20,
+		FailureThreshold: 0.5,
+		CooldownDuration: 30 * time.Second,
+		HalfOpenProbes:   1,
+	}
+}
+
+// breaker tracks the state for a single destination host.
+type breaker struct {
+	mu         sync.Mutex
+	state      state
+	outcomes   []bool
+	openedAt   time.Time
+	probesUsed int
+}
+
+// Registry holds one breaker per destination host, created lazily, all
+// sharing the same Config.
+type Registry struct {
+	config        Config
+	mu            sync.Mutex
+	breakers      map[string]*breaker
+	onStateChange func(host, from, to string)
+}
+
+// NewRegistry creates a Registry of per-host breakers governed by config.
+func NewRegistry(config Config) *Registry {
+	return &Registry{config: config, breakers: make(map[string]*breaker)}
+}
+
+// NewRegistryWithObservability creates a Registry like NewRegistry, and also
+// wires its state transitions to logFile and to the CircuitBreakerOpen
+// metric, so an open breaker is visible without having to read logs for it.
+func NewRegistryWithObservability(config Config, logFile *logfile.Logger) *Registry {
+	r := NewRegistry(config)
+	r.OnStateChange(func(host, from, to string) {
+		logFile.Write("Circuit breaker for host " + host + " transitioned from " + from + " to " + to)
+
+		if to == "open" {
+			metrics.CircuitBreakerOpen.WithLabelValues(host).Set(1)
+		} else {
+			metrics.CircuitBreakerOpen.WithLabelValues(host).Set(0)
+		}
+	})
+	return r
+}
+
+// OnStateChange registers a callback invoked whenever a host's breaker
+// transitions between "closed", "open" and "half-open", e.g. for logging or metrics.
+func (r *Registry) OnStateChange(fn func(host, from, to string)) {
+	r.onStateChange = fn
+}
+
+func (r *Registry) breakerFor(host string) *breaker {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	b, ok := r.breakers[host]
+	if !ok {
+		b = &breaker{state: closed}
+		r.breakers[host] = b
+	}
+	return b
+}
+
+// Allow reports whether a request to host may proceed. An open breaker
+// transitions to half-open once CooldownDuration has elapsed, allowing a
+// limited number of probe requests through.
+func (r *Registry) Allow(host string) bool {
+	b := r.breakerFor(host)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == open && time.Since(b.openedAt) >= r.config.CooldownDuration {
+		r.transition(host, b, halfOpen)
+		b.probesUsed = 0
+	}
+
+	switch b.state {
+	case closed:
+		return true
+	case halfOpen:
+		if b.probesUsed >= r.config.HalfOpenProbes {
+			return false
+		}
+		b.probesUsed++
+		return true
+	default:
+		return false
+	}
+}
+
+// RecordResult updates the breaker for host with the outcome of a request,
+// tripping it open or closing it as appropriate.
+func (r *Registry) RecordResult(host string, success bool) {
+	b := r.breakerFor(host)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == halfOpen {
+		if success {
+			r.transition(host, b, closed)
+			b.outcomes = nil
+		} else {
+			r.transition(host, b, open)
+			b.openedAt = time.Now()
+		}
+		return
+	}
+
+	b.outcomes = append(b.outcomes, success)
+	if len(b.outcomes) > r.config.WindowSize {
+		b.outcomes = b.outcomes[len(b.outcomes)-r.config.WindowSize:]
+	}
+
+	if b.state == closed && len(b.outcomes) >= r.config.WindowSize {
+		failures := 0
+		for _, o := range b.outcomes {
+			if !o {
+				failures++
+			}
+		}
+		if float64(failures)/float64(len(b.outcomes)) > r.config.FailureThreshold {
+			r.transition(host, b, open)
+			b.openedAt = time.Now()
+		}
+	}
+}
+
+// transition moves b to the new state and, if it actually changed, notifies
+// the registered OnStateChange callback. Caller must hold b.mu.
+func (r *Registry) transition(host string, b *breaker, to state) {
+	from := b.state
+	b.state = to
+	if r.onStateChange != nil && from != to {
+		r.onStateChange(host, stateName(from), stateName(to))
+	}
+}
+
+func stateName(s state) string {
+	switch s {
+	case closed:
+		return "closed"
+	case open:
+		return "open"
+	case halfOpen:
+		return "half-open"
+	default:
+		return "unknown"
+	}
+}
@@ -0,0 +1,180 @@
+package circuitbreaker
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRegistry_StaysClosedAtFailureRatioBoundary(t *testing.T) {
+	r := NewRegistry(Config{
+		WindowSize:       10,
+		FailureThreshold: 0.5,
+		CooldownDuration: time.Hour,
+		HalfOpenProbes:   1,
+	})
+
+	// 5 failures + 5 successes is a 0.5 ratio, which does not exceed a 0.5
+	// FailureThreshold (the trip condition is a strict '>'), so the breaker
+	// should stay closed right at the boundary.
+	for i := 0; i < 5; i++ {
+		r.RecordResult("api.example.com", false)
+	}
+	for i := 0; i < 5; i++ {
+		r.RecordResult("api.example.com", true)
+	}
+	if !r.Allow("api.example.com") {
+		t.Fatal("expected breaker to still be closed at the failure ratio boundary")
+	}
+}
+
+func TestRegistry_TripsOpenAboveFailureThreshold(t *testing.T) {
+	r := NewRegistry(Config{
+		WindowSize:       10,
+		FailureThreshold: 0.5,
+		CooldownDuration: time.Hour,
+		HalfOpenProbes:   1,
+	})
+
+	// 6 failures + 4 successes is a 0.6 ratio, genuinely over the 0.5
+	// FailureThreshold, which should trip the breaker open.
+	for i := 0; i < 6; i++ {
+		r.RecordResult("api.example.com", false)
+	}
+	for i := 0; i < 4; i++ {
+		r.RecordResult("api.example.com", true)
+	}
+	if r.Allow("api.example.com") {
+		t.Fatal("expected breaker to be open once the failure ratio exceeds the threshold")
+	}
+}
+
+func TestRegistry_StaysClosedBelowWindowSize(t *testing.T) {
+	r := NewRegistry(Config{
+		WindowSize:       10,
+		FailureThreshold: 0.5,
+		CooldownDuration: time.Hour,
+		HalfOpenProbes:   1,
+	})
+
+	// Only 3 outcomes recorded, all failures - below WindowSize, so the
+	// ratio isn't evaluated yet.
+	for i := 0; i < 3; i++ {
+		r.RecordResult("api.example.com", false)
+	}
+	if !r.Allow("api.example.com") {
+		t.Error("expected breaker to stay closed until WindowSize outcomes are recorded")
+	}
+}
+
+func TestRegistry_HalfOpenAfterCooldown(t *testing.T) {
+	r := NewRegistry(Config{
+		WindowSize:       2,
+		FailureThreshold: 0.5,
+		CooldownDuration: 10 * time.Millisecond,
+		HalfOpenProbes:   1,
+	})
+
+	r.RecordResult("api.example.com", false)
+	r.RecordResult("api.example.com", false)
+	if r.Allow("api.example.com") {
+		t.Fatal("expected breaker to be open immediately after tripping")
+	}
+
+	time.Sleep(20 * time.Millisecond)
+
+	if !r.Allow("api.example.com") {
+		t.Fatal("expected breaker to allow a half-open probe after cooldown")
+	}
+	// HalfOpenProbes is 1, so a second call before a result is recorded
+	// should not be allowed.
+	if r.Allow("api.example.com") {
+		t.Fatal("expected only one half-open probe to be allowed at a time")
+	}
+}
+
+func TestRegistry_HalfOpenSuccessCloses(t *testing.T) {
+	r := NewRegistry(Config{
+		WindowSize:       2,
+		FailureThreshold: 0.5,
+		CooldownDuration: 10 * time.Millisecond,
+		HalfOpenProbes:   1,
+	})
+
+	r.RecordResult("api.example.com", false)
+	r.RecordResult("api.example.com", false)
+	time.Sleep(20 * time.Millisecond)
+	r.Allow("api.example.com")
+
+	r.RecordResult("api.example.com", true)
+	if !r.Allow("api.example.com") {
+		t.Fatal("expected breaker to close after a successful half-open probe")
+	}
+}
+
+func TestRegistry_HalfOpenFailureReopens(t *testing.T) {
+	r := NewRegistry(Config{
+		WindowSize:       2,
+		FailureThreshold: 0.5,
+		CooldownDuration: 10 * time.Millisecond,
+		HalfOpenProbes:   1,
+	})
+
+	r.RecordResult("api.example.com", false)
+	r.RecordResult("api.example.com", false)
+	time.Sleep(20 * time.Millisecond)
+	r.Allow("api.example.com")
+
+	r.RecordResult("api.example.com", false)
+	if r.Allow("api.example.com") {
+		t.Fatal("expected breaker to re-open after a failed half-open probe")
+	}
+}
+
+func TestRegistry_OnStateChangeNotifiedOnTransitions(t *testing.T) {
+	r := NewRegistry(Config{
+		WindowSize:       2,
+		FailureThreshold: 0.5,
+		CooldownDuration: 10 * time.Millisecond,
+		HalfOpenProbes:   1,
+	})
+
+	var transitions []string
+	r.OnStateChange(func(host, from, to string) {
+		transitions = append(transitions, from+"->"+to)
+	})
+
+	r.RecordResult("api.example.com", false)
+	r.RecordResult("api.example.com", false)
+	time.Sleep(20 * time.Millisecond)
+	r.Allow("api.example.com")
+	r.RecordResult("api.example.com", true)
+
+	expected := []string{"closed->open", "open->half-open", "half-open->closed"}
+	if len(transitions) != len(expected) {
+		t.Fatalf("expected transitions %v, got %v", expected, transitions)
+	}
+	for i, e := range expected {
+		if transitions[i] != e {
+			t.Errorf("transition %d: expected %q, got %q", i, e, transitions[i])
+		}
+	}
+}
+
+func TestRegistry_BreakersAreIndependentPerHost(t *testing.T) {
+	r := NewRegistry(Config{
+		WindowSize:       2,
+		FailureThreshold: 0.5,
+		CooldownDuration: time.Hour,
+		HalfOpenProbes:   1,
+	})
+
+	r.RecordResult("api.example.com", false)
+	r.RecordResult("api.example.com", false)
+
+	if r.Allow("api.example.com") {
+		t.Error("expected api.example.com breaker to be open")
+	}
+	if !r.Allow("other.example.com") {
+		t.Error("expected other.example.com breaker to be unaffected and closed")
+	}
+}
@@ -0,0 +1,94 @@
+// Package event publishes structured result events for each http attempt
+// made by the worker, so downstream services can subscribe to specific
+// failure classes (e.g. http.5xx) instead of polling logs.
+package event
+
+import (
+	"encoding/json"
+	"github.com/streadway/amqp"
+	"net/url"
+	"time"
+)
+
+// Event types published after an http attempt.
+const (
+	TypeSuccess = "http.success"
+	TypeRetry   = "http.retry"
+	TypeDropped = "http.dropped"
+	Type4xx     = "http.4xx"
+	Type5xx     = "http.5xx"
+)
+
+const respSnippetMaxLen = 500
+
+// Result is the structured payload published after every http attempt.
+type Result struct {
+	MessageId   string `json:"message_id"`
+	Url         string `json:"url"`
+	Attempt     int    `json:"attempt"`
+	LatencyMs   int64  `json:"latency_ms"`
+	StatusCode  int    `json:"status_code,omitempty"`
+	RespSnippet string `json:"response_snippet,omitempty"`
+	Error       string `json:"error,omitempty"`
+}
+
+// Emitter publishes a Result for a given event type. Implementations back
+// this with RabbitMQ (the default), NATS, Kafka, etc.
+type Emitter interface {
+	Emit(eventType string, result Result) error
+}
+
+// NoopEmitter discards all events. It is used when no Emitter is configured.
+type NoopEmitter struct{}
+
+func (NoopEmitter) Emit(eventType string, result Result) error {
+	return nil
+}
+
+// RabbitMQEmitter publishes events to a RabbitMQ topic exchange, with a
+// routing key derived from the event type and the target host, e.g.
+// "http.retry.api.example.com".
+type RabbitMQEmitter struct {
+	Channel  *amqp.Channel
+	Exchange string
+}
+
+// NewRabbitMQEmitter declares exchange as a topic exchange on channel and
+// returns an Emitter that publishes result events to it.
+func NewRabbitMQEmitter(channel *amqp.Channel, exchange string) (*RabbitMQEmitter, error) {
+	if err := channel.ExchangeDeclare(exchange, "topic", true, false, false, false, nil); err != nil {
+		return nil, err
+	}
+
+	return &RabbitMQEmitter{Channel: channel, Exchange: exchange}, nil
+}
+
+func (e *RabbitMQEmitter) Emit(eventType string, result Result) error {
+	if len(result.RespSnippet) > respSnippetMaxLen {
+		result.RespSnippet = result.RespSnippet[:respSnippetMaxLen]
+	}
+
+	body, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+
+	routingKey := eventType
+	if host := hostFromUrl(result.Url); len(host) > 0 {
+		routingKey += "." + host
+	}
+
+	return e.Channel.Publish(e.Exchange, routingKey, false, false, amqp.Publishing{
+		ContentType: "application/json",
+		Timestamp:   time.Now(),
+		Body:        body,
+	})
+}
+
+func hostFromUrl(rawUrl string) string {
+	parsed, err := url.Parse(rawUrl)
+	if err != nil {
+		return ""
+	}
+	return parsed.Hostname()
+}
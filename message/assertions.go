@@ -0,0 +1,98 @@
+package message
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// validAssertionTypes are the assertion types a message may specify via the
+// 'success_assertions' field.
+var validAssertionTypes = map[string]bool{
+	"jsonpath": true,
+	"regex":    true,
+	"contains": true,
+	"header":   true,
+}
+
+// Assertion is evaluated against the http response even when the status code
+// is a success, so upstreams that return e.g. HTTP 200 with an error payload
+// can still be treated as a failure.
+type Assertion struct {
+	Type       string
+	Expression string
+	Expected   string
+}
+
+// evaluateAssertions runs assertions against the http response header and
+// body, returning the first failure encountered, or nil if all passed (or
+// none were configured).
+func evaluateAssertions(assertions []Assertion, header http.Header, body string) error {
+	for _, a := range assertions {
+		switch a.Type {
+		case "contains":
+			if !strings.Contains(body, a.Expression) {
+				return errors.New("success_assertions: response body does not contain '" + a.Expression + "'")
+			}
+
+		case "regex":
+			matched, err := regexp.MatchString(a.Expression, body)
+			if err != nil {
+				return err
+			}
+			if !matched {
+				return errors.New("success_assertions: response body does not match regex '" + a.Expression + "'")
+			}
+
+		case "header":
+			if header.Get(a.Expression) != a.Expected {
+				return errors.New("success_assertions: header '" + a.Expression + "' does not equal '" + a.Expected + "'")
+			}
+
+		case "jsonpath":
+			val, err := jsonPathLookup(body, a.Expression)
+			if err != nil {
+				return errors.New("success_assertions: " + err.Error())
+			}
+			if val != a.Expected {
+				return errors.New("success_assertions: jsonpath '" + a.Expression + "' value '" + val + "' does not equal '" + a.Expected + "'")
+			}
+		}
+	}
+
+	return nil
+}
+
+// jsonPathLookup resolves a minimal dot-notation path (e.g. "data.status",
+// optionally prefixed with "$.") against a JSON document, scalar values are
+// compared as their string representation.
+func jsonPathLookup(body string, path string) (string, error) {
+	var doc interface{}
+	if err := json.Unmarshal([]byte(body), &doc); err != nil {
+		return "", err
+	}
+
+	current := doc
+	for _, segment := range strings.Split(strings.TrimPrefix(path, "$."), ".") {
+		m, ok := current.(map[string]interface{})
+		if !ok {
+			return "", errors.New("'" + segment + "' is not a json object")
+		}
+		current, ok = m[segment]
+		if !ok {
+			return "", errors.New("field '" + segment + "' not found")
+		}
+	}
+
+	if str, ok := current.(string); ok {
+		return str, nil
+	}
+
+	encoded, err := json.Marshal(current)
+	if err != nil {
+		return "", err
+	}
+	return string(encoded), nil
+}
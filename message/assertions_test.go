@@ -0,0 +1,102 @@
+package message
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestEvaluateAssertions_Contains(t *testing.T) {
+	assertions := []Assertion{{Type: "contains", Expression: "ok"}}
+
+	if err := evaluateAssertions(assertions, http.Header{}, `{"status":"ok"}`); err != nil {
+		t.Errorf("expected no error, got %v", err)
+	}
+	if err := evaluateAssertions(assertions, http.Header{}, `{"status":"error"}`); err == nil {
+		t.Error("expected an error when body does not contain expression")
+	}
+}
+
+func TestEvaluateAssertions_Regex(t *testing.T) {
+	assertions := []Assertion{{Type: "regex", Expression: `^\{"status":"ok"\}$`}}
+
+	if err := evaluateAssertions(assertions, http.Header{}, `{"status":"ok"}`); err != nil {
+		t.Errorf("expected no error, got %v", err)
+	}
+	if err := evaluateAssertions(assertions, http.Header{}, `{"status":"error"}`); err == nil {
+		t.Error("expected an error when body does not match regex")
+	}
+}
+
+func TestEvaluateAssertions_InvalidRegexReturnsError(t *testing.T) {
+	assertions := []Assertion{{Type: "regex", Expression: `(`}}
+
+	if err := evaluateAssertions(assertions, http.Header{}, "anything"); err == nil {
+		t.Error("expected an error for an invalid regex")
+	}
+}
+
+func TestEvaluateAssertions_Header(t *testing.T) {
+	header := http.Header{}
+	header.Set("X-Request-Status", "ok")
+	assertions := []Assertion{{Type: "header", Expression: "X-Request-Status", Expected: "ok"}}
+
+	if err := evaluateAssertions(assertions, header, ""); err != nil {
+		t.Errorf("expected no error, got %v", err)
+	}
+
+	assertions[0].Expected = "failed"
+	if err := evaluateAssertions(assertions, header, ""); err == nil {
+		t.Error("expected an error when header value does not match")
+	}
+}
+
+func TestEvaluateAssertions_JsonPath(t *testing.T) {
+	body := `{"data":{"status":"ok","count":2}}`
+
+	if err := evaluateAssertions([]Assertion{{Type: "jsonpath", Expression: "data.status", Expected: "ok"}}, http.Header{}, body); err != nil {
+		t.Errorf("expected no error, got %v", err)
+	}
+
+	if err := evaluateAssertions([]Assertion{{Type: "jsonpath", Expression: "data.count", Expected: "2"}}, http.Header{}, body); err != nil {
+		t.Errorf("expected no error comparing a numeric field as a string, got %v", err)
+	}
+
+	if err := evaluateAssertions([]Assertion{{Type: "jsonpath", Expression: "data.status", Expected: "error"}}, http.Header{}, body); err == nil {
+		t.Error("expected an error when jsonpath value does not match")
+	}
+}
+
+func TestEvaluateAssertions_FirstFailureWins(t *testing.T) {
+	assertions := []Assertion{
+		{Type: "contains", Expression: "ok"},
+		{Type: "contains", Expression: "missing"},
+	}
+
+	if err := evaluateAssertions(assertions, http.Header{}, `{"status":"ok"}`); err == nil {
+		t.Error("expected the second assertion's failure to be returned")
+	}
+}
+
+func TestJsonPathLookup(t *testing.T) {
+	body := `{"data":{"status":"ok","nested":{"id":5}}}`
+
+	if val, err := jsonPathLookup(body, "data.status"); err != nil || val != "ok" {
+		t.Errorf("expected 'ok', got %q, err %v", val, err)
+	}
+
+	if val, err := jsonPathLookup(body, "$.data.nested.id"); err != nil || val != "5" {
+		t.Errorf("expected '5' with leading '$.' stripped, got %q, err %v", val, err)
+	}
+
+	if _, err := jsonPathLookup(body, "data.missing"); err == nil {
+		t.Error("expected an error for a missing field")
+	}
+
+	if _, err := jsonPathLookup(body, "data.status.nope"); err == nil {
+		t.Error("expected an error when descending into a non-object")
+	}
+
+	if _, err := jsonPathLookup("not json", "data.status"); err == nil {
+		t.Error("expected an error for an invalid json body")
+	}
+}
@@ -2,17 +2,33 @@ package message
 
 import (
 	"bytes"
+	"context"
 	"crypto/md5"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"github.com/LinioIT/rabbitmq-worker/circuitbreaker"
+	"github.com/LinioIT/rabbitmq-worker/event"
 	"github.com/LinioIT/rabbitmq-worker/logfile"
+	"github.com/LinioIT/rabbitmq-worker/metrics"
+	"github.com/LinioIT/rabbitmq-worker/tracing"
 	"github.com/streadway/amqp"
 	"io/ioutil"
 	"net/http"
+	"net/url"
+	"strings"
 	"time"
 )
 
+// validHttpMethods are the methods a message may request via the 'method' field.
+var validHttpMethods = map[string]bool{
+	"GET":    true,
+	"POST":   true,
+	"PUT":    true,
+	"PATCH":  true,
+	"DELETE": true,
+}
+
 // HttpRequestMessage holds all info and status for a RabbitMQ message and its associated http request.
 type HttpRequestMessage struct {
 	// RabbitMQ message
@@ -23,9 +39,28 @@ type HttpRequestMessage struct {
 	MessageId string
 
 	// Http request fields
-	Url     string
-	Headers map[string]string
-	Body    string
+	Url         string
+	Method      string
+	Headers     map[string]string
+	QueryParams map[string]string
+	Body        string
+
+	// BodyEncoding is either "json" (default) or "form". For "form", Body
+	// must be a JSON object; Parse re-encodes it as
+	// "application/x-www-form-urlencoded" and sets Content-Type to match.
+	BodyEncoding string
+
+	// ExpectedStatus overrides the default 2XX-success rule, if provided.
+	ExpectedStatus []int
+
+	// SuccessAssertions are evaluated against the response headers/body even
+	// when the http status is a success, so upstreams that return e.g. 200
+	// with an error payload are still treated as a failure.
+	SuccessAssertions []Assertion
+
+	// OnFailure is "retry" (default) or "drop", applied when a success
+	// assertion fails.
+	OnFailure string
 
 	// Time when message was originally created (if timestamp plugin was installed)
 	Timestamp int64
@@ -38,20 +73,55 @@ type HttpRequestMessage struct {
 	RetryCnt           int
 	FirstRejectionTime int64
 
+	// Policy governing max attempts, backoff and which statuses are
+	// retryable. Defaults to DefaultRetryPolicy() if MaxAttempts is zero.
+	RetryPolicy RetryPolicy
+
 	// Http request status
 	HttpStatusMsg string
 	HttpRespBody  string
 	HttpErr       error
 
+	// Wait-queue TTL (milliseconds), computed by RetryPolicy from RetryCnt,
+	// to publish as the per-message x-message-ttl on retry. Only meaningful
+	// when Drop is false.
+	WaitTTL int64
+
 	// Drop / Retry Indicator - Set after http request attempt
 	Drop bool
+
+	// Ctx carries the active trace span across Parse and Do, set during
+	// Parse. Do uses context.Background() if it is nil.
+	Ctx context.Context
 }
 
-func (msg *HttpRequestMessage) Parse(rmqDelivery amqp.Delivery, logFile *logfile.Logger) (err error) {
+func (msg *HttpRequestMessage) Parse(rmqDelivery amqp.Delivery, logFile *logfile.Logger, tracer tracing.Tracer, queue string) (err error) {
+	if tracer == nil {
+		tracer = tracing.NoopTracer{}
+	}
+
+	traceCtx := tracing.ExtractFromHeaders(rmqDelivery.Headers)
+	ctx, span := tracer.Start(tracing.ContextWithTraceContext(context.Background(), traceCtx), tracing.SpanConsume)
+	defer func() {
+		if err != nil {
+			span.SetError(err)
+		}
+		span.End()
+	}()
+	msg.Ctx = ctx
+
+	metrics.MessagesConsumedTotal.WithLabelValues(queue).Inc()
+
 	type MessageFields struct {
-		Url     string
-		Headers []map[string]string
-		Body    string
+		Url               string
+		Method            string
+		Headers           []map[string]string
+		QueryParams       []map[string]string `json:"query_params"`
+		Body              string
+		BodyEncoding      string      `json:"body_encoding"`
+		ExpectedStatus    []int       `json:"expected_status"`
+		SuccessAssertions []Assertion `json:"success_assertions"`
+		OnFailure         string      `json:"on_failure"`
 	}
 
 	var fields MessageFields
@@ -70,6 +140,18 @@ func (msg *HttpRequestMessage) Parse(rmqDelivery amqp.Delivery, logFile *logfile
 	}
 	msg.Url = fields.Url
 
+	// Method - defaults to POST, preserving the module's original behavior
+	if len(fields.Method) == 0 {
+		msg.Method = "POST"
+	} else {
+		method := strings.ToUpper(fields.Method)
+		if !validHttpMethods[method] {
+			err = errors.New("Field 'method' value '" + fields.Method + "' is not a supported http method")
+			return err
+		}
+		msg.Method = method
+	}
+
 	// Http headers
 	msg.Headers = make(map[string]string)
 	for _, m := range fields.Headers {
@@ -78,9 +160,66 @@ func (msg *HttpRequestMessage) Parse(rmqDelivery amqp.Delivery, logFile *logfile
 		}
 	}
 
+	// Query parameters
+	msg.QueryParams = make(map[string]string)
+	for _, m := range fields.QueryParams {
+		for key, val := range m {
+			msg.QueryParams[key] = val
+		}
+	}
+
+	// Body encoding - defaults to json
+	if len(fields.BodyEncoding) == 0 {
+		msg.BodyEncoding = "json"
+	} else if fields.BodyEncoding != "json" && fields.BodyEncoding != "form" {
+		err = errors.New("Field 'body_encoding' value '" + fields.BodyEncoding + "' is not 'json' or 'form'")
+		return err
+	} else {
+		msg.BodyEncoding = fields.BodyEncoding
+	}
+
 	// Request body
 	msg.Body = fields.Body
 
+	// For form encoding, 'body' must be a JSON object; re-encode it as
+	// "key1=val1&key2=val2" so BodyEncoding actually changes what goes on
+	// the wire, not just the Content-Type header.
+	if msg.BodyEncoding == "form" {
+		var bodyFields map[string]interface{}
+		if err = json.Unmarshal([]byte(fields.Body), &bodyFields); err != nil {
+			err = errors.New("Field 'body' must be a JSON object when 'body_encoding' is 'form': " + err.Error())
+			return err
+		}
+
+		formValues := url.Values{}
+		for key, val := range bodyFields {
+			formValues.Set(key, fmt.Sprintf("%v", val))
+		}
+		msg.Body = formValues.Encode()
+	}
+
+	// Expected status codes - overrides the default 2XX-success rule
+	msg.ExpectedStatus = fields.ExpectedStatus
+
+	// Success assertions, checked against the response even on a success status
+	for _, a := range fields.SuccessAssertions {
+		if !validAssertionTypes[a.Type] {
+			err = errors.New("Field 'success_assertions' has unsupported type '" + a.Type + "'")
+			return err
+		}
+	}
+	msg.SuccessAssertions = fields.SuccessAssertions
+
+	// On Failure - defaults to retry
+	if len(fields.OnFailure) == 0 {
+		msg.OnFailure = "retry"
+	} else if fields.OnFailure != "retry" && fields.OnFailure != "drop" {
+		err = errors.New("Field 'on_failure' value '" + fields.OnFailure + "' is not 'retry' or 'drop'")
+		return err
+	} else {
+		msg.OnFailure = fields.OnFailure
+	}
+
 	/*** Extract fields from RabbitMQ message properties ***/
 	// Message creation timestamp
 	if !rmqDelivery.Timestamp.IsZero() {
@@ -163,56 +302,328 @@ func getRetryInfo(rmqHeaders amqp.Table) (retryCnt int, firstRejectionTime int64
 	return
 }
 
-func (msg HttpRequestMessage) HttpPost(ackCh chan HttpRequestMessage, timeout int) {
-	req, err := http.NewRequest("POST", msg.Url, bytes.NewBufferString(msg.Body))
+// buildUrl appends QueryParams to msg.Url, if any were provided.
+func (msg HttpRequestMessage) buildUrl() (string, error) {
+	if len(msg.QueryParams) == 0 {
+		return msg.Url, nil
+	}
+
+	parsedUrl, err := url.Parse(msg.Url)
+	if err != nil {
+		return "", err
+	}
+
+	query := parsedUrl.Query()
+	for key, val := range msg.QueryParams {
+		query.Set(key, val)
+	}
+	parsedUrl.RawQuery = query.Encode()
+
+	return parsedUrl.String(), nil
+}
+
+// isSuccessStatus reports whether statusCode is a success, per ExpectedStatus
+// if it was provided, or the default 2XX rule otherwise.
+func (msg HttpRequestMessage) isSuccessStatus(statusCode int) bool {
+	if len(msg.ExpectedStatus) > 0 {
+		for _, expected := range msg.ExpectedStatus {
+			if statusCode == expected {
+				return true
+			}
+		}
+		return false
+	}
+
+	return statusCode >= 200 && statusCode <= 299
+}
+
+func (msg HttpRequestMessage) Do(tracer tracing.Tracer, logFile *logfile.Logger, emitter event.Emitter, breaker *circuitbreaker.Registry, waitQueue *WaitQueuePublisher, ackCh chan HttpRequestMessage, timeout int) {
+	if tracer == nil {
+		tracer = tracing.NoopTracer{}
+	}
+	if emitter == nil {
+		emitter = event.NoopEmitter{}
+	}
+
+	ctx := msg.Ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	ctx, span := tracer.Start(ctx, tracing.SpanHttpClient)
+
+	policy := msg.RetryPolicy
+	if policy.MaxAttempts == 0 {
+		policy = DefaultRetryPolicy()
+	}
+
+	method := msg.Method
+	if len(method) == 0 {
+		method = "POST"
+	}
+
+	start := time.Now()
+	statusCode := 0
+	bytesRead := 0
+	eventType := event.TypeRetry
+	skipHttpMetrics := false
+
+	defer func() {
+		span.SetAttribute("http.method", method)
+		span.SetAttribute("http.url", msg.Url)
+		span.SetAttribute("http.status_code", statusCode)
+		span.SetAttribute("retry_count", msg.RetryCnt)
+		if msg.HttpErr != nil {
+			span.SetError(msg.HttpErr)
+		}
+		span.End()
+
+		latency := time.Since(start)
+		logRequestEvent(logFile, msg, method, statusCode, latency, bytesRead)
+		emitResult(emitter, eventType, msg, statusCode, latency)
+
+		// skipHttpMetrics is set when no http request was actually sent
+		// (e.g. the circuit breaker short-circuited it), so it shouldn't
+		// be counted as an outbound http request or timed as one.
+		if !skipHttpMetrics {
+			metrics.HttpRequestsTotal.WithLabelValues(method, statusClassLabel(statusCode)).Inc()
+			metrics.HttpRequestDuration.Observe(latency.Seconds())
+		}
+		if eventType == event.TypeRetry {
+			metrics.RetriesTotal.Inc()
+		} else if msg.Drop && eventType != event.TypeSuccess {
+			metrics.DroppedTotal.WithLabelValues(eventType).Inc()
+		}
+	}()
+
+	requestUrl, err := msg.buildUrl()
+	if err != nil {
+		msg.HttpErr = err
+		msg.HttpStatusMsg = "Invalid query parameters: " + err.Error()
+		msg.Drop = true
+		eventType = event.TypeDropped
+		skipHttpMetrics = true
+		ackCh <- msg
+		return
+	}
+
+	req, err := http.NewRequest(method, requestUrl, bytes.NewBufferString(msg.Body))
 	if err != nil {
 		msg.HttpErr = err
 		msg.HttpStatusMsg = "Invalid http request: " + err.Error()
 		msg.Drop = true
+		eventType = event.TypeDropped
+		skipHttpMetrics = true
 		ackCh <- msg
 		return
 	}
+	req = req.WithContext(ctx)
 
-	client := &http.Client{Timeout: time.Duration(timeout) * time.Second}
+	if msg.BodyEncoding == "form" {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	} else {
+		req.Header.Set("Content-Type", "application/json")
+	}
 
 	for hkey, hval := range msg.Headers {
 		req.Header.Set(hkey, hval)
 	}
 
+	if traceCtx, ok := tracing.TraceContextFromContext(ctx); ok {
+		traceCtx.ApplyToRequest(req.Header)
+	}
+	tracer.Inject(ctx, req.Header)
+
+	host := req.URL.Host
+	if breaker != nil && !breaker.Allow(host) {
+		msg.HttpErr = errors.New("circuit breaker open for host " + host)
+		skipHttpMetrics = true
+
+		if policy.Exhausted(msg.RetryCnt) {
+			msg.Drop = true
+			eventType = event.TypeDropped
+			ackCh <- msg
+			return
+		}
+
+		msg.WaitTTL = policy.NextInterval(msg.RetryCnt).Milliseconds()
+		requeue(waitQueue, msg, logFile)
+		ackCh <- msg
+		return
+	}
+
+	client := &http.Client{Timeout: time.Duration(timeout) * time.Second}
+
+	metrics.InflightRequests.Inc()
 	resp, err := client.Do(req)
+	metrics.InflightRequests.Dec()
+
+	if breaker != nil {
+		breaker.RecordResult(host, err == nil && resp.StatusCode < 500)
+	}
 
 	if err != nil {
 		msg.HttpErr = err
-		msg.HttpStatusMsg = "Error on http POST: " + err.Error()
+		msg.HttpStatusMsg = "Error on http " + method + ": " + err.Error()
+
+		if policy.Exhausted(msg.RetryCnt) {
+			msg.Drop = true
+			eventType = event.TypeDropped
+			ackCh <- msg
+			return
+		}
+
+		msg.WaitTTL = policy.NextInterval(msg.RetryCnt).Milliseconds()
+		requeue(waitQueue, msg, logFile)
 		ackCh <- msg
 		return
 	} else {
+		statusCode = resp.StatusCode
 		htmlData, err := ioutil.ReadAll(resp.Body)
 
-		// The response body is not currently used to evaluate success of the http request. Therefore, an error here is not fatal.
-		// This will change if functionality is added to evaluate the response body.
+		// The response body is captured for logging and for success_assertions below.
 		if err != nil {
-			msg.HttpRespBody = "Error encountered when reading POST response body"
+			msg.HttpRespBody = "Error encountered when reading " + method + " response body"
 		} else {
 			msg.HttpStatusMsg = resp.Status
 			msg.HttpRespBody = string(htmlData)
+			bytesRead = len(htmlData)
 			resp.Body.Close()
 		}
 	}
 
-	if resp.StatusCode >= 400 && resp.StatusCode <= 499 {
-		msg.HttpErr = errors.New("4XX status on http POST (no retry): " + resp.Status)
+	if msg.isSuccessStatus(resp.StatusCode) {
+		if assertErr := evaluateAssertions(msg.SuccessAssertions, resp.Header, msg.HttpRespBody); assertErr != nil {
+			msg.HttpErr = assertErr
+
+			if msg.OnFailure == "drop" {
+				msg.Drop = true
+				eventType = event.TypeDropped
+				ackCh <- msg
+				return
+			}
+
+			if policy.Exhausted(msg.RetryCnt) {
+				msg.Drop = true
+				eventType = event.TypeDropped
+				ackCh <- msg
+				return
+			}
+
+			msg.WaitTTL = policy.NextInterval(msg.RetryCnt).Milliseconds()
+			requeue(waitQueue, msg, logFile)
+			ackCh <- msg
+			return
+		}
+
+		msg.Drop = true
+		eventType = event.TypeSuccess
+		ackCh <- msg
+		return
+	}
+
+	if !policy.Retryable(resp.StatusCode) {
+		msg.HttpErr = errors.New(resp.Status + " status on http " + method + " (not retryable)")
 		msg.Drop = true
+		eventType = statusEventType(resp.StatusCode)
 		ackCh <- msg
 		return
 	}
 
-	if resp.StatusCode >= 200 && resp.StatusCode <= 299 {
+	if policy.Exhausted(msg.RetryCnt) {
+		msg.HttpErr = errors.New("Max retry attempts exceeded on http " + method + ": " + resp.Status)
 		msg.Drop = true
+		eventType = statusEventType(resp.StatusCode)
 		ackCh <- msg
 		return
 	}
 
-	msg.HttpErr = errors.New("Error on http POST: " + resp.Status)
+	msg.HttpErr = errors.New("Error on http " + method + ": " + resp.Status)
+	msg.WaitTTL = policy.NextInterval(msg.RetryCnt).Milliseconds()
+	requeue(waitQueue, msg, logFile)
 	ackCh <- msg
 }
+
+// requeue republishes msg to the wait queue with its computed WaitTTL as the
+// per-message x-message-ttl, so each retry backs off independently instead
+// of sharing one queue-wide wait TTL. Publish errors are logged, not fatal:
+// the message is still acked below, and RabbitMQ's own queue-level TTL (if
+// configured) acts as a fallback.
+func requeue(waitQueue *WaitQueuePublisher, msg HttpRequestMessage, logFile *logfile.Logger) {
+	if waitQueue == nil {
+		return
+	}
+	if err := waitQueue.Publish(msg); err != nil {
+		logFile.Write("Error republishing message " + msg.MessageId + " to wait queue: " + err.Error())
+	}
+}
+
+// statusEventType classifies a response status code into the 4xx/5xx event
+// types, falling back to a generic drop for anything else.
+func statusEventType(statusCode int) string {
+	switch {
+	case statusCode >= 400 && statusCode <= 499:
+		return event.Type4xx
+	case statusCode >= 500 && statusCode <= 599:
+		return event.Type5xx
+	default:
+		return event.TypeDropped
+	}
+}
+
+// statusClassLabel formats statusCode as a Prometheus label value, e.g. "2xx".
+// A statusCode of 0 (the request never got a response) is reported as "0xx".
+func statusClassLabel(statusCode int) string {
+	if statusCode == 0 {
+		return "0xx"
+	}
+	return fmt.Sprintf("%dxx", statusCode/100)
+}
+
+// logRequestEvent writes a structured JSON log line for a single http
+// attempt, via logFile.
+func logRequestEvent(logFile *logfile.Logger, msg HttpRequestMessage, method string, statusCode int, latency time.Duration, bytesRead int) {
+	entry := struct {
+		MessageId  string `json:"message_id"`
+		Method     string `json:"method"`
+		Attempt    int    `json:"attempt"`
+		StatusCode int    `json:"status_code"`
+		LatencyMs  int64  `json:"latency_ms"`
+		Bytes      int    `json:"bytes"`
+		Error      string `json:"error,omitempty"`
+	}{
+		MessageId:  msg.MessageId,
+		Method:     method,
+		Attempt:    msg.RetryCnt + 1,
+		StatusCode: statusCode,
+		LatencyMs:  latency.Milliseconds(),
+		Bytes:      bytesRead,
+	}
+
+	if msg.HttpErr != nil {
+		entry.Error = msg.HttpErr.Error()
+	}
+
+	if encoded, err := json.Marshal(entry); err == nil {
+		logFile.Write(string(encoded))
+	}
+}
+
+// emitResult publishes a Result event for a single http attempt via emitter.
+// Publish errors are intentionally swallowed - a broken event bus shouldn't
+// affect message processing.
+func emitResult(emitter event.Emitter, eventType string, msg HttpRequestMessage, statusCode int, latency time.Duration) {
+	result := event.Result{
+		MessageId:   msg.MessageId,
+		Url:         msg.Url,
+		Attempt:     msg.RetryCnt + 1,
+		LatencyMs:   latency.Milliseconds(),
+		StatusCode:  statusCode,
+		RespSnippet: msg.HttpRespBody,
+	}
+
+	if msg.HttpErr != nil {
+		result.Error = msg.HttpErr.Error()
+	}
+
+	emitter.Emit(eventType, result)
+}
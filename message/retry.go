@@ -0,0 +1,88 @@
+package message
+
+import (
+	"math"
+	"math/rand"
+	"time"
+)
+
+// RetryPolicy controls how many times a failed http request will be retried,
+// how long the worker waits between attempts, and which status codes are
+// considered retryable. It replaces the previous "dead-letter and retry
+// forever on any non-4XX" behavior with a configurable, Traefik/go-retryablehttp
+// style policy.
+type RetryPolicy struct {
+	// MaxAttempts is the total number of times a message will be sent,
+	// including the first attempt. Once RetryCnt has used up all but the
+	// last attempt, the message is dropped instead of retried.
+	MaxAttempts int
+
+	// InitialInterval is the wait-queue delay before the first retry.
+	InitialInterval time.Duration
+
+	// MaxInterval caps the computed wait-queue delay.
+	MaxInterval time.Duration
+
+	// Multiplier grows the delay on each successive retry.
+	Multiplier float64
+
+	// RandomizationFactor jitters the computed delay by +/- this fraction,
+	// e.g. 0.2 randomizes the delay within [interval*0.8, interval*1.2].
+	RandomizationFactor float64
+
+	// RetryableStatus decides whether a non-2XX status code should be
+	// retried. If nil, all 5XX statuses are retryable and all others
+	// (including 4XX) are dropped.
+	RetryableStatus func(statusCode int) bool
+}
+
+// DefaultRetryPolicy returns the policy applied to a message when none was
+// explicitly set.
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts:         10,
+		InitialInterval:     30 * time.Second,
+		MaxInterval:         1 * time.Hour,
+		Multiplier:          2.0,
+		RandomizationFactor: 0.2,
+	}
+}
+
+// NextInterval computes the wait-queue delay before the retry following
+// attempt, using the standard exponential backoff recurrence with jitter:
+//
+//	interval = min(MaxInterval, InitialInterval * Multiplier^attempt)
+//	interval = interval * (1 +/- RandomizationFactor * rand)
+func (p RetryPolicy) NextInterval(attempt int) time.Duration {
+	interval := float64(p.InitialInterval) * math.Pow(p.Multiplier, float64(attempt))
+	if max := float64(p.MaxInterval); max > 0 && interval > max {
+		interval = max
+	}
+
+	if p.RandomizationFactor > 0 {
+		delta := p.RandomizationFactor * interval
+		interval = interval - delta + (rand.Float64() * 2 * delta)
+	}
+
+	if interval < 0 {
+		interval = 0
+	}
+
+	return time.Duration(interval)
+}
+
+// Retryable reports whether statusCode should be retried, per RetryableStatus
+// if one was supplied, or the default rule (5XX only) otherwise.
+func (p RetryPolicy) Retryable(statusCode int) bool {
+	if p.RetryableStatus != nil {
+		return p.RetryableStatus(statusCode)
+	}
+	return statusCode >= 500 && statusCode <= 599
+}
+
+// Exhausted reports whether the attempt made after retryCnt earlier attempts
+// is the last one this policy allows, so a failure should be dropped rather
+// than retried.
+func (p RetryPolicy) Exhausted(retryCnt int) bool {
+	return p.MaxAttempts > 0 && retryCnt+1 >= p.MaxAttempts
+}
@@ -0,0 +1,122 @@
+package message
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNextInterval_CapsAtMaxInterval(t *testing.T) {
+	policy := RetryPolicy{
+		InitialInterval:     1 * time.Second,
+		MaxInterval:         5 * time.Second,
+		Multiplier:          2.0,
+		RandomizationFactor: 0,
+	}
+
+	// Uncapped this would be 1s * 2^10 = 1024s, well past MaxInterval.
+	interval := policy.NextInterval(10)
+	if interval != 5*time.Second {
+		t.Errorf("expected interval capped at 5s, got %v", interval)
+	}
+}
+
+func TestNextInterval_GrowsExponentially(t *testing.T) {
+	policy := RetryPolicy{
+		InitialInterval:     1 * time.Second,
+		MaxInterval:         1 * time.Hour,
+		Multiplier:          2.0,
+		RandomizationFactor: 0,
+	}
+
+	cases := []struct {
+		attempt  int
+		expected time.Duration
+	}{
+		{0, 1 * time.Second},
+		{1, 2 * time.Second},
+		{2, 4 * time.Second},
+		{3, 8 * time.Second},
+	}
+
+	for _, c := range cases {
+		if got := policy.NextInterval(c.attempt); got != c.expected {
+			t.Errorf("attempt %d: expected %v, got %v", c.attempt, c.expected, got)
+		}
+	}
+}
+
+func TestNextInterval_JitterStaysWithinBounds(t *testing.T) {
+	policy := RetryPolicy{
+		InitialInterval:     10 * time.Second,
+		MaxInterval:         1 * time.Hour,
+		Multiplier:          1.0,
+		RandomizationFactor: 0.2,
+	}
+
+	lower := 8 * time.Second
+	upper := 12 * time.Second
+
+	for i := 0; i < 50; i++ {
+		interval := policy.NextInterval(0)
+		if interval < lower || interval > upper {
+			t.Fatalf("jittered interval %v out of bounds [%v, %v]", interval, lower, upper)
+		}
+	}
+}
+
+func TestRetryable_DefaultsTo5xxOnly(t *testing.T) {
+	policy := RetryPolicy{}
+
+	if !policy.Retryable(503) {
+		t.Error("expected 503 to be retryable by default")
+	}
+	if policy.Retryable(404) {
+		t.Error("expected 404 to not be retryable by default")
+	}
+}
+
+func TestRetryable_UsesCustomPredicate(t *testing.T) {
+	policy := RetryPolicy{
+		RetryableStatus: func(statusCode int) bool {
+			return statusCode == 429
+		},
+	}
+
+	if !policy.Retryable(429) {
+		t.Error("expected 429 to be retryable per the custom predicate")
+	}
+	if policy.Retryable(503) {
+		t.Error("expected 503 to not be retryable per the custom predicate")
+	}
+}
+
+func TestExhausted_OffByOneOnMaxAttempts(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 3}
+
+	// retryCnt is the number of attempts already made; retryCnt+1 is the
+	// attempt about to be sent. Exhausted once that attempt would be the
+	// MaxAttempts-th (or later) one.
+	cases := []struct {
+		retryCnt int
+		expected bool
+	}{
+		{0, false},
+		{1, false},
+		{2, true},
+		{3, true},
+	}
+
+	for _, c := range cases {
+		if got := policy.Exhausted(c.retryCnt); got != c.expected {
+			t.Errorf("retryCnt %d: expected Exhausted=%v, got %v", c.retryCnt, c.expected, got)
+		}
+	}
+}
+
+func TestExhausted_UnlimitedWhenMaxAttemptsIsZero(t *testing.T) {
+	policy := RetryPolicy{}
+
+	if policy.Exhausted(1000) {
+		t.Error("expected Exhausted to be false when MaxAttempts is unset")
+	}
+}
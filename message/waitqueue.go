@@ -0,0 +1,32 @@
+package message
+
+import (
+	"github.com/streadway/amqp"
+	"strconv"
+)
+
+// WaitQueuePublisher republishes a message to the RabbitMQ wait queue ahead
+// of a retry, carrying the original RabbitMQ message forward and setting a
+// per-message x-message-ttl (the AMQP 'expiration' property) from
+// RetryPolicy's computed backoff, rather than relying on a single queue-wide
+// wait TTL.
+type WaitQueuePublisher struct {
+	Channel    *amqp.Channel
+	Exchange   string
+	RoutingKey string
+}
+
+// Publish republishes msg onto the wait queue, using msg.WaitTTL
+// (milliseconds) as the per-message TTL. It is a no-op if w is nil.
+func (w *WaitQueuePublisher) Publish(msg HttpRequestMessage) error {
+	if w == nil {
+		return nil
+	}
+
+	return w.Channel.Publish(w.Exchange, w.RoutingKey, false, false, amqp.Publishing{
+		Headers:     msg.Delivery.Headers,
+		ContentType: msg.Delivery.ContentType,
+		Body:        msg.Delivery.Body,
+		Expiration:  strconv.FormatInt(msg.WaitTTL, 10),
+	})
+}
@@ -0,0 +1,103 @@
+// Package metrics exposes a /metrics endpoint with Prometheus counters,
+// histograms and gauges populated from the HttpRequestMessage lifecycle.
+package metrics
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"io/ioutil"
+	"net/http"
+)
+
+var (
+	MessagesConsumedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "rmqworker_messages_consumed_total",
+		Help: "Total number of RabbitMQ messages consumed, by queue.",
+	}, []string{"queue"})
+
+	HttpRequestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "rmqworker_http_requests_total",
+		Help: "Total number of outbound http requests, by method and status class.",
+	}, []string{"method", "status_class"})
+
+	HttpRequestDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "rmqworker_http_request_duration_seconds",
+		Help:    "Outbound http request latency, in seconds.",
+		Buckets: prometheus.DefBuckets,
+	})
+
+	RetriesTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "rmqworker_retries_total",
+		Help: "Total number of http requests scheduled for retry.",
+	})
+
+	DroppedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "rmqworker_dropped_total",
+		Help: "Total number of messages dropped, by reason.",
+	}, []string{"reason"})
+
+	InflightRequests = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "rmqworker_inflight_requests",
+		Help: "Number of outbound http requests currently in flight.",
+	})
+
+	QueueDepth = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "rmqworker_queue_depth",
+		Help: "RabbitMQ queue depth, polled via the management HTTP API.",
+	}, []string{"queue"})
+
+	CircuitBreakerOpen = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "rmqworker_circuit_breaker_open",
+		Help: "Whether the per-host circuit breaker is open (1) or not (0).",
+	}, []string{"host"})
+)
+
+// Serve exposes the /metrics endpoint on addr (e.g. ":9100") for Prometheus to scrape.
+func Serve(addr string) error {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	return http.ListenAndServe(addr, mux)
+}
+
+// managementQueue is the subset of the RabbitMQ management API's
+// /api/queues response this package cares about.
+type managementQueue struct {
+	Name     string `json:"name"`
+	Messages int    `json:"messages"`
+}
+
+// PollQueueDepths fetches current queue depths from the RabbitMQ management
+// HTTP API (GET {managementUrl}/api/queues/{vhost}) and updates QueueDepth,
+// the same way telegraf's rabbitmq plugin polls /api/overview and /api/queues.
+func PollQueueDepths(managementUrl, vhost, user, password string) error {
+	req, err := http.NewRequest("GET", fmt.Sprintf("%s/api/queues/%s", managementUrl, vhost), nil)
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(user, password)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	var queues []managementQueue
+	if err := json.Unmarshal(body, &queues); err != nil {
+		return err
+	}
+
+	for _, q := range queues {
+		QueueDepth.WithLabelValues(q.Name).Set(float64(q.Messages))
+	}
+
+	return nil
+}
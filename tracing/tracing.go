@@ -0,0 +1,102 @@
+// Package tracing provides an OpenTelemetry-style Tracer abstraction and W3C
+// Trace Context propagation, so the worker can plug in a real tracing
+// backend (or none at all) without the message package depending on one.
+package tracing
+
+import (
+	"context"
+	"net/http"
+)
+
+// Span names used around message consumption and the outbound http request.
+const (
+	SpanConsume    = "rabbitmq.consume"
+	SpanHttpClient = "http.client"
+)
+
+// Span represents a single unit of tracing work.
+type Span interface {
+	// SetAttribute records a key/value pair on the span, e.g. http.method, http.status_code.
+	SetAttribute(key string, value interface{})
+
+	// SetError marks the span as failed, recording err.
+	SetError(err error)
+
+	// End closes the span.
+	End()
+}
+
+// Tracer starts spans and propagates trace context across the RabbitMQ ->
+// http request boundary. Users plug in an OTel-backed implementation, or use
+// NoopTracer if tracing isn't configured.
+type Tracer interface {
+	// Start begins a new span named name, as a child of any span already
+	// present in ctx, and returns the updated context along with the span.
+	Start(ctx context.Context, name string) (context.Context, Span)
+
+	// Inject writes the current span's trace context into header, using the
+	// W3C Trace Context format (traceparent/tracestate).
+	Inject(ctx context.Context, header http.Header)
+}
+
+// NoopTracer discards all spans. It is used when no Tracer is configured.
+type NoopTracer struct{}
+
+func (NoopTracer) Start(ctx context.Context, name string) (context.Context, Span) {
+	return ctx, noopSpan{}
+}
+
+func (NoopTracer) Inject(ctx context.Context, header http.Header) {}
+
+type noopSpan struct{}
+
+func (noopSpan) SetAttribute(key string, value interface{}) {}
+func (noopSpan) SetError(err error)                         {}
+func (noopSpan) End()                                       {}
+
+// TraceContext holds the W3C trace context extracted from RabbitMQ message headers.
+type TraceContext struct {
+	TraceParent string
+	TraceState  string
+}
+
+type traceContextKey struct{}
+
+// ContextWithTraceContext stores tc in ctx, so a Tracer can use it as the
+// parent of the root span it starts.
+func ContextWithTraceContext(ctx context.Context, tc TraceContext) context.Context {
+	return context.WithValue(ctx, traceContextKey{}, tc)
+}
+
+// TraceContextFromContext retrieves the TraceContext stored by
+// ContextWithTraceContext, if any.
+func TraceContextFromContext(ctx context.Context) (TraceContext, bool) {
+	tc, ok := ctx.Value(traceContextKey{}).(TraceContext)
+	return tc, ok
+}
+
+// ExtractFromHeaders pulls the W3C traceparent/tracestate values out of
+// RabbitMQ message headers (amqp.Table), returning the zero value if absent.
+func ExtractFromHeaders(headers map[string]interface{}) TraceContext {
+	var tc TraceContext
+
+	if v, ok := headers["traceparent"].(string); ok {
+		tc.TraceParent = v
+	}
+	if v, ok := headers["tracestate"].(string); ok {
+		tc.TraceState = v
+	}
+
+	return tc
+}
+
+// ApplyToRequest injects the extracted trace context into an outbound http
+// request's headers, so it propagates to the downstream service.
+func (tc TraceContext) ApplyToRequest(header http.Header) {
+	if len(tc.TraceParent) > 0 {
+		header.Set("traceparent", tc.TraceParent)
+	}
+	if len(tc.TraceState) > 0 {
+		header.Set("tracestate", tc.TraceState)
+	}
+}